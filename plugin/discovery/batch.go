@@ -0,0 +1,46 @@
+package discovery
+
+import (
+	"fmt"
+
+	multierror "github.com/hashicorp/go-multierror"
+)
+
+// BatchInstaller installs many providers at once, in parallel, on top of a
+// single ProviderInstaller. It exists as a thin, explicitly-named wrapper
+// around GetMany for callers (such as "terraform init") that want to
+// install a whole config's worth of providers and get back a single
+// aggregated error rather than dealing with the per-provider InstallErrors
+// map directly.
+//
+// Concurrent workers coordinate on the underlying ProviderInstaller's
+// PluginCache via an on-disk lock file per provider+version+os+arch (see
+// lockCacheFile), so unrelated providers install fully in parallel while
+// same-cache-entry races are serialized. Ui output is likewise serialized
+// by GetMany so that concurrent workers' progress lines are not
+// interleaved.
+type BatchInstaller struct {
+	*ProviderInstaller
+}
+
+// NewBatchInstaller wraps installer for batch installation.
+func NewBatchInstaller(installer *ProviderInstaller) *BatchInstaller {
+	return &BatchInstaller{ProviderInstaller: installer}
+}
+
+// Get resolves and installs every provider in reqs, in parallel, returning
+// the metadata for everything that installed successfully along with a
+// *multierror.Error describing any failures. Providers that fail do not
+// prevent the others from completing.
+func (b *BatchInstaller) Get(reqs map[string]Constraints) (PluginMetaSet, error) {
+	metas, errs := b.GetMany(reqs)
+	if len(errs) == 0 {
+		return metas, nil
+	}
+
+	var result *multierror.Error
+	for name, err := range errs {
+		result = multierror.Append(result, fmt.Errorf("provider %q: %s", name, err))
+	}
+	return metas, result
+}
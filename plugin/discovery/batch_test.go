@@ -0,0 +1,108 @@
+package discovery
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hashicorp/terraform/registry"
+	"github.com/mitchellh/cli"
+)
+
+// dirPluginCache is a minimal PluginCache backed by a single directory,
+// sufficient for exercising the install-time locking added for
+// BatchInstaller without depending on the full cache implementation.
+type dirPluginCache struct {
+	dir string
+}
+
+func (c *dirPluginCache) InstallDir() string { return c.dir }
+
+func (c *dirPluginCache) CachedPluginPath(kind, name string, version Version) string {
+	matches, _ := filepath.Glob(filepath.Join(c.dir, fmt.Sprintf("terraform-%s-%s_v%s*", kind, name, version)))
+	if len(matches) == 0 {
+		return ""
+	}
+	return matches[0]
+}
+
+func TestBatchInstallerGetAggregatesErrors(t *testing.T) {
+	server := genericTestServer(nil)
+	defer server.Close()
+
+	tmpDir, err := ioutil.TempDir("", "tf-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	b := NewBatchInstaller(&ProviderInstaller{
+		Dir: tmpDir,
+		PluginProtocolVersion: 3,
+		SkipVerify:            true,
+		Ui:                    cli.NewMockUi(),
+		registry:              registry.NewClient(Disco(server), nil, nil),
+	})
+
+	_, err = b.Get(map[string]Constraints{
+		"test":     AllVersions,
+		"nonexist": AllVersions,
+	})
+	if err == nil {
+		t.Fatal("expected an aggregated error for the missing provider")
+	}
+}
+
+// TestInstallLocksCacheEntry verifies that concurrent installs of the same
+// provider+version only extract the archive into the cache once.
+func TestInstallLocksCacheEntry(t *testing.T) {
+	var extractCount int32
+	server := genericTestServer(func(provider string) error {
+		atomic.AddInt32(&extractCount, 1)
+		return nil
+	})
+	defer server.Close()
+
+	cacheDir, err := ioutil.TempDir("", "tf-plugin-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cacheDir)
+
+	cache := &dirPluginCache{dir: cacheDir}
+
+	var wg sync.WaitGroup
+	for n := 0; n < 4; n++ {
+		installDir, err := ioutil.TempDir("", "tf-plugin-install")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(installDir)
+
+		i := &ProviderInstaller{
+			Dir: installDir,
+			PluginProtocolVersion: 3,
+			SkipVerify:            true,
+			Ui:                    cli.NewMockUi(),
+			registry:              registry.NewClient(Disco(server), nil, nil),
+			Cache:                 cache,
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := i.Get("test", AllVersions); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&extractCount); got != 1 {
+		t.Fatalf("expected the archive to be extracted exactly once, got %d", got)
+	}
+}
@@ -0,0 +1,309 @@
+package discovery
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/hashicorp/terraform/registry/response"
+	"github.com/mitchellh/cli"
+)
+
+// BundleManifestFilename is the name of the JSON manifest that describes
+// the contents of a BundleInstaller's directory.
+const BundleManifestFilename = "terraform-provider-manifest.json"
+
+// BundleManifestEntry describes one pre-downloaded provider release
+// archive available in a bundle directory.
+type BundleManifestEntry struct {
+	Provider  string   `json:"provider"`
+	Version   string   `json:"version"`
+	OS        string   `json:"os"`
+	Arch      string   `json:"arch"`
+	Filename  string   `json:"filename"`
+	SHA256    string   `json:"sha256"`
+	Protocols []string `json:"protocols"`
+}
+
+// BundleManifest is the JSON document, named BundleManifestFilename, found
+// at the root of a bundle directory.
+type BundleManifest struct {
+	Providers []BundleManifestEntry `json:"providers"`
+}
+
+// BundleInstaller is an Installer (see Installer) that resolves providers
+// from a local directory of pre-downloaded release archives plus a
+// manifest, rather than from the Terraform registry. It is intended for
+// air-gapped environments, where the bundle directory is prepared ahead of
+// time (for example with WriteMirrorManifest) and carried to the target
+// machine.
+type BundleInstaller struct {
+	// Dir holds the manifest and the release archives it describes.
+	Dir string
+
+	// Cache is used to access and update a local cache of plugins if
+	// non-nil, exactly as with ProviderInstaller.
+	Cache PluginCache
+
+	PluginProtocolVersion uint
+
+	OS   string
+	Arch string
+
+	Ui cli.Ui
+
+	manifest *BundleManifest
+}
+
+var _ Installer = (*BundleInstaller)(nil)
+
+// Get implements Installer.
+func (b *BundleInstaller) Get(provider string, req Constraints) (PluginMeta, error) {
+	if b.OS == "" {
+		b.OS = runtime.GOOS
+	}
+	if b.Arch == "" {
+		b.Arch = runtime.GOARCH
+	}
+
+	manifest, err := b.loadManifest()
+	if err != nil {
+		return PluginMeta{}, err
+	}
+
+	versions, err := manifestVersions(manifest, provider, b.OS, b.Arch)
+	if err != nil {
+		return PluginMeta{}, err
+	}
+	if len(versions) == 0 {
+		return PluginMeta{}, ErrorNoSuchProvider
+	}
+
+	allowed := allowedVersions(&response.TerraformProviderVersions{ID: provider, Versions: versions}, req)
+	if len(allowed) == 0 {
+		return PluginMeta{}, ErrorNoSuitableVersion
+	}
+	response.Collection(allowed).Sort()
+
+	entry, err := b.resolveEntry(provider, allowed)
+	if err != nil {
+		return PluginMeta{}, err
+	}
+
+	v, _ := VersionStr(entry.Version).Parse()
+
+	if err := os.MkdirAll(b.Dir, os.ModePerm); err != nil {
+		return PluginMeta{}, fmt.Errorf("failed to create plugin dir %s: %s", b.Dir, err)
+	}
+
+	archivePath := filepath.Join(b.Dir, entry.Filename)
+	sum, err := fileSHA256(archivePath)
+	if err != nil {
+		return PluginMeta{}, fmt.Errorf("error checksumming %s: %s", archivePath, err)
+	}
+	if sum != entry.SHA256 {
+		return PluginMeta{}, fmt.Errorf("checksum mismatch for %s: manifest says %s, archive is %s", entry.Filename, entry.SHA256, sum)
+	}
+
+	// Use a file:// URL so that go-getter still applies its normal
+	// unarchive behavior, and so the existing "?checksum=" mechanism
+	// continues to guard against the archive changing underneath us
+	// between the check above and the extraction below.
+	archiveURL := &url.URL{Scheme: "file", Path: filepath.ToSlash(archivePath)}
+	q := archiveURL.Query()
+	q.Set("checksum", "sha256:"+sum)
+	archiveURL.RawQuery = q.Encode()
+
+	if err := installFromURL(b.Dir, b.Cache, b.OS, b.Arch, provider, v, archiveURL.String(), NilProgressListener{}); err != nil {
+		return PluginMeta{}, err
+	}
+
+	metas := FindPlugins("provider", []string{b.Dir})
+	metas, _ = metas.ValidateVersions()
+	metas = metas.WithName(provider).WithVersion(v)
+	if metas.Count() == 0 {
+		return PluginMeta{}, fmt.Errorf(
+			"failed to find installed plugin version %s; this is a bug in Terraform and should be reported",
+			entry.Version,
+		)
+	}
+	return metas.Newest(), nil
+}
+
+// PurgeUnused implements Installer, identically to ProviderInstaller.
+func (b *BundleInstaller) PurgeUnused(used map[string]PluginMeta) (PluginMetaSet, error) {
+	i := &ProviderInstaller{Dir: b.Dir}
+	return i.PurgeUnused(used)
+}
+
+// resolveEntry picks the newest protocol-compatible version (allowed is
+// already sorted newest-to-oldest) and returns the manifest entry backing
+// it.
+func (b *BundleInstaller) resolveEntry(provider string, allowed []*response.TerraformProviderVersion) (BundleManifestEntry, error) {
+	manifest, err := b.loadManifest()
+	if err != nil {
+		return BundleManifestEntry{}, err
+	}
+
+	pi := &ProviderInstaller{PluginProtocolVersion: b.PluginProtocolVersion}
+	for _, versionMeta := range allowed {
+		if err := pi.checkPluginProtocol(versionMeta); err != nil {
+			continue
+		}
+		for _, e := range manifest.Providers {
+			if e.Provider == provider && e.Version == versionMeta.Version && e.OS == b.OS && e.Arch == b.Arch {
+				return e, nil
+			}
+		}
+	}
+	return BundleManifestEntry{}, ErrorNoVersionCompatible
+}
+
+// manifestVersions collects the distinct versions available for provider
+// in the given os/arch, in the response.TerraformProviderVersion shape the
+// rest of the package's resolution logic already understands.
+func manifestVersions(manifest *BundleManifest, provider, os, arch string) ([]*response.TerraformProviderVersion, error) {
+	byVersion := map[string]*response.TerraformProviderVersion{}
+	for _, e := range manifest.Providers {
+		if e.Provider != provider || e.OS != os || e.Arch != arch {
+			continue
+		}
+		v, ok := byVersion[e.Version]
+		if !ok {
+			v = &response.TerraformProviderVersion{
+				Version:   e.Version,
+				Protocols: e.Protocols,
+				Platforms: []*response.TerraformProviderPlatform{{OS: e.OS, Arch: e.Arch}},
+			}
+			byVersion[e.Version] = v
+		}
+	}
+
+	var versions []*response.TerraformProviderVersion
+	for _, v := range byVersion {
+		versions = append(versions, v)
+	}
+	return versions, nil
+}
+
+func (b *BundleInstaller) loadManifest() (*BundleManifest, error) {
+	if b.manifest != nil {
+		return b.manifest, nil
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(b.Dir, BundleManifestFilename))
+	if err != nil {
+		return nil, fmt.Errorf("error reading bundle manifest: %s", err)
+	}
+
+	var manifest BundleManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("error parsing bundle manifest: %s", err)
+	}
+	b.manifest = &manifest
+	return &manifest, nil
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// downloadMirrorArchive resolves the newest version of provider allowed by
+// req, exactly as ProviderInstaller.Get does, but rather than extracting
+// the release archive into i.Dir it copies the archive itself into destDir
+// (unmodified, so that a BundleInstaller can later re-verify and extract it
+// the same way ProviderInstaller.Get would) and returns the
+// BundleManifestEntry describing it.
+func (i *ProviderInstaller) downloadMirrorArchive(provider string, req Constraints, destDir string) (BundleManifestEntry, error) {
+	versionMeta, downloadURLs, err := i.resolveVersion(provider, req)
+	if err != nil {
+		return BundleManifestEntry{}, err
+	}
+
+	archive, err := getFile(downloadURLs.DownloadURL)
+	if err != nil {
+		return BundleManifestEntry{}, fmt.Errorf("error downloading %s %s: %s", provider, versionMeta.Version, err)
+	}
+
+	archivePath := filepath.Join(destDir, downloadURLs.Filename)
+	if err := ioutil.WriteFile(archivePath, archive, 0644); err != nil {
+		return BundleManifestEntry{}, fmt.Errorf("error writing mirrored archive %s: %s", archivePath, err)
+	}
+
+	sum, err := fileSHA256(archivePath)
+	if err != nil {
+		return BundleManifestEntry{}, fmt.Errorf("error checksumming %s: %s", archivePath, err)
+	}
+
+	if !i.SkipVerify {
+		expected, _, err := i.getProviderChecksum(downloadURLs)
+		if err != nil {
+			return BundleManifestEntry{}, err
+		}
+		if expected != "" && expected != sum {
+			return BundleManifestEntry{}, fmt.Errorf(
+				"checksum mismatch mirroring %s %s: wrote %s, registry advertises %s",
+				provider, versionMeta.Version, sum, expected,
+			)
+		}
+	}
+
+	return BundleManifestEntry{
+		Provider:  provider,
+		Version:   versionMeta.Version,
+		OS:        i.OS,
+		Arch:      i.Arch,
+		Filename:  downloadURLs.Filename,
+		SHA256:    sum,
+		Protocols: versionMeta.Protocols,
+	}, nil
+}
+
+// WriteMirrorManifest downloads the release archives for the given
+// providers from the registry via installer and writes them, plus a
+// BundleManifest describing them, into destDir, so that the directory can
+// be carried to an air-gapped environment and used as a BundleInstaller's
+// Dir. This is the building block for a "terraform providers mirror"
+// command.
+func WriteMirrorManifest(installer *ProviderInstaller, destDir string, reqs map[string]Constraints) error {
+	if err := os.MkdirAll(destDir, os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create mirror dir %s: %s", destDir, err)
+	}
+
+	manifest := &BundleManifest{}
+
+	for name, req := range reqs {
+		mirrorOne := *installer
+
+		entry, err := mirrorOne.downloadMirrorArchive(name, req, destDir)
+		if err != nil {
+			return fmt.Errorf("error mirroring provider %q: %s", name, err)
+		}
+
+		manifest.Providers = append(manifest.Providers, entry)
+	}
+
+	js, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding mirror manifest: %s", err)
+	}
+
+	return ioutil.WriteFile(filepath.Join(destDir, BundleManifestFilename), js, 0644)
+}
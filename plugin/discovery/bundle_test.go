@@ -0,0 +1,181 @@
+package discovery
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/hashicorp/terraform/registry"
+	"github.com/mitchellh/cli"
+)
+
+func writeTestArchive(t *testing.T, path, entryName string) {
+	t.Helper()
+
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	z := zip.NewWriter(f)
+	w, err := z.Create(entryName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(w, testProviderFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBundleInstallerGet(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "tf-plugin-bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	archiveName := "terraform-provider-test_1.2.3_" + runtime.GOOS + "_" + runtime.GOARCH + ".zip"
+	writeTestArchive(t, filepath.Join(tmpDir, archiveName), "terraform-provider-test_v1.2.3_x3")
+
+	sum, err := fileSHA256(filepath.Join(tmpDir, archiveName))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest := BundleManifest{
+		Providers: []BundleManifestEntry{
+			{
+				Provider:  "test",
+				Version:   "1.2.3",
+				OS:        runtime.GOOS,
+				Arch:      runtime.GOARCH,
+				Filename:  archiveName,
+				SHA256:    sum,
+				Protocols: []string{"3"},
+			},
+		},
+	}
+	js, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, BundleManifestFilename), js, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	b := &BundleInstaller{
+		Dir: tmpDir,
+		PluginProtocolVersion: 3,
+		Ui:                    cli.NewMockUi(),
+	}
+
+	meta, err := b.Get("test", AllVersions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Name != "test" || meta.Version != VersionStr("1.2.3") {
+		t.Fatalf("unexpected meta: %#v", meta)
+	}
+}
+
+func TestBundleInstallerGetChecksumMismatch(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "tf-plugin-bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	archiveName := "terraform-provider-test_1.2.3_" + runtime.GOOS + "_" + runtime.GOARCH + ".zip"
+	writeTestArchive(t, filepath.Join(tmpDir, archiveName), "terraform-provider-test_v1.2.3_x3")
+
+	manifest := BundleManifest{
+		Providers: []BundleManifestEntry{
+			{
+				Provider:  "test",
+				Version:   "1.2.3",
+				OS:        runtime.GOOS,
+				Arch:      runtime.GOARCH,
+				Filename:  archiveName,
+				SHA256:    "0000000000000000000000000000000000000000000000000000000000000",
+				Protocols: []string{"3"},
+			},
+		},
+	}
+	js, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, BundleManifestFilename), js, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	b := &BundleInstaller{
+		Dir: tmpDir,
+		PluginProtocolVersion: 3,
+		Ui:                    cli.NewMockUi(),
+	}
+
+	if _, err := b.Get("test", AllVersions); err == nil {
+		t.Fatal("expected checksum mismatch error, got nil")
+	}
+}
+
+// TestWriteMirrorManifestRoundTrip verifies that a directory produced by
+// WriteMirrorManifest is actually usable as a BundleInstaller's Dir: the
+// mirrored release archive, not just the unpacked binary, must be present
+// so that BundleInstaller.Get can re-verify and extract it.
+func TestWriteMirrorManifestRoundTrip(t *testing.T) {
+	server := genericTestServer(nil)
+	defer server.Close()
+
+	destDir, err := ioutil.TempDir("", "tf-plugin-mirror")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(destDir)
+
+	installer := &ProviderInstaller{
+		PluginProtocolVersion: 3,
+		SkipVerify:            true,
+		Ui:                    cli.NewMockUi(),
+		registry:              registry.NewClient(Disco(server), nil, nil),
+	}
+
+	err = WriteMirrorManifest(installer, destDir, map[string]Constraints{
+		"test": AllVersions,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	matches, err := filepath.Glob(filepath.Join(destDir, "*.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 {
+		t.Fatalf("expected exactly one mirrored archive, got %#v", matches)
+	}
+
+	b := &BundleInstaller{
+		Dir: destDir,
+		PluginProtocolVersion: 3,
+		Ui:                    cli.NewMockUi(),
+	}
+
+	meta, err := b.Get("test", AllVersions)
+	if err != nil {
+		t.Fatalf("mirrored bundle is not installable: %s", err)
+	}
+	if meta.Name != "test" {
+		t.Fatalf("unexpected meta: %#v", meta)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -81,6 +82,33 @@ type ProviderInstaller struct {
 	// credentials pre-loaded.
 	Services *disco.Disco
 
+	// Concurrency bounds how many providers GetMany will resolve and
+	// download at once. Defaults to defaultGetManyConcurrency if unset.
+	Concurrency int
+
+	// Progress, if set, receives structured progress events as GetMany
+	// (and so also Get) resolves and downloads providers.
+	Progress ProgressListener
+
+	// Verifier checks the SHA256SUMS signature for a downloaded provider.
+	// If nil, a GPGSignatureVerifier is constructed automatically the
+	// first time it's needed, trusting the embedded HashiCorp key plus
+	// whatever KeyDir and Services contribute.
+	Verifier SignatureVerifier
+
+	// KeyDir, if set, is a directory of ASCII-armored public keys that
+	// the user has chosen to trust, loaded into the default Verifier's
+	// keyring. Ignored if Verifier is set explicitly.
+	KeyDir string
+
+	// Sources, if non-empty, are consulted in order before falling back to
+	// the registry for version listings and download locations. This
+	// allows providers to be resolved from a local mirror, an internal
+	// HTTP index, or similar, which is useful for air-gapped installs and
+	// for caching provider downloads across CI runs. The first source to
+	// return a non-empty result for a given provider wins.
+	Sources []InstallerSource
+
 	// registry client
 	registry *registry.Client
 
@@ -108,7 +136,25 @@ type ProviderInstaller struct {
 // are produced under the assumption that if presented to the user they will
 // be presented alongside context about what is being installed, and thus the
 // error messages do not redundantly include such information.
+//
+// Get installs a single provider; to install several at once, with
+// concurrency and per-provider retry, use GetMany instead. Get is in fact
+// implemented in terms of GetMany, as the single-provider case.
 func (i *ProviderInstaller) Get(provider string, req Constraints) (PluginMeta, error) {
+	metas, errs := i.GetMany(map[string]Constraints{provider: req})
+	if err, ok := errs[provider]; ok {
+		return PluginMeta{}, err
+	}
+	return metas.Newest(), nil
+}
+
+// resolveVersion finds the newest version of provider allowed by req that
+// is compatible with both i.PluginProtocolVersion and i.OS/i.Arch, looking
+// it up via i.Sources or the registry, and returns its metadata plus its
+// download location. It's shared by getOne and WriteMirrorManifest, which
+// both need to resolve a provider version but differ in what they do with
+// the result once resolved.
+func (i *ProviderInstaller) resolveVersion(provider string, req Constraints) (*response.TerraformProviderVersion, *response.TerraformProviderPlatformLocation, error) {
 	// a little bit of initialization
 	// I don't think this goes here. Suggestions?
 	if i.OS == "" {
@@ -126,28 +172,43 @@ func (i *ProviderInstaller) Get(provider string, req Constraints) (PluginMeta, e
 
 	// TODO: return multiple errors
 	if err != nil {
-		return PluginMeta{}, ErrorNoSuchProvider
+		return nil, nil, ErrorNoSuchProvider
 	}
 	if len(allVersions.Versions) == 0 {
-		return PluginMeta{}, ErrorNoSuitableVersion
+		return nil, nil, ErrorNoSuitableVersion
 	}
 
 	// Filter the list of plugin versions to those which meet the version constraints
 	versions := allowedVersions(allVersions, req)
 	if len(versions) == 0 {
-		return PluginMeta{}, ErrorNoSuitableVersion
+		return nil, nil, ErrorNoSuitableVersion
 	}
 
 	// sort them newest to oldest
 	response.Collection(versions).Sort()
 
-	// the winning version is the newest
-	versionMeta := versions[0]
-	if len(versionMeta.Protocols) == 0 {
-		return PluginMeta{}, fmt.Errorf("no provider protocols listed")
+	// Walking newest to oldest, find the newest version that is compatible
+	// with both our plugin protocol and our OS/Arch. We don't stop at the
+	// first version we encounter that is merely protocol-compatible,
+	// because that version might not have a matching platform, and an
+	// older version might have both. An older version is only chosen when
+	// every newer version meeting the constraints is incompatible.
+	//
+	// see RFC TF-055: Provider Protocol Versioning for more information
+	// on plugin protocol compatibility.
+	versionMeta, downloadURLs, newestProtoCompatible, err := i.resolveCompatibleVersion(provider, versions)
+	if err != nil {
+		return nil, nil, compatibilityError(provider, i.OS, i.Arch, newestProtoCompatible, err)
 	}
-	if len(versionMeta.Platforms) == 0 {
-		return PluginMeta{}, fmt.Errorf("no provider platforms listed")
+	return versionMeta, downloadURLs, nil
+}
+
+// getOne is the single-provider resolve-download-verify-install sequence
+// that GetMany drives concurrently, with retries, across many providers.
+func (i *ProviderInstaller) getOne(provider string, req Constraints) (PluginMeta, error) {
+	versionMeta, downloadURLs, err := i.resolveVersion(provider, req)
+	if err != nil {
+		return PluginMeta{}, err
 	}
 
 	// get a Version from the version string
@@ -160,33 +221,10 @@ func (i *ProviderInstaller) Get(provider string, req Constraints) (PluginMeta, e
 		return PluginMeta{}, fmt.Errorf("failed to create plugin dir %s: %s", i.Dir, err)
 	}
 
-	// check plugin protocol compatibility
-	// We only validate the most recent version that meets the version constraints.
-	// see RFC TF-055: Provider Protocol Versioning for more information
-	err = i.checkPluginProtocol(versionMeta)
-	if err != nil {
-		return PluginMeta{}, err
-	}
-
-	var downloadURLs *response.TerraformProviderPlatformLocation
-	// check plugin platform compatibility
-	for _, p := range versionMeta.Platforms {
-		if p.Arch == i.Arch && p.OS == i.OS {
-			downloadURLs, err = i.listProviderDownloadURLs(provider, versionMeta.Version)
-			if err != nil {
-				return PluginMeta{}, err
-			}
-			break
-		}
-		// TODO: return the most recent compatible versions
-		// return PluginMeta{}, ErrorNoVersionCompatibleWithPlatform
-		return PluginMeta{}, fmt.Errorf("The latest version of plugin %q does not support the requested platform %s %s", provider, i.OS, i.Arch)
-	}
-
 	providerURL := downloadURLs.DownloadURL
 
 	if !i.SkipVerify {
-		sha256, err := i.getProviderChecksum(downloadURLs)
+		sha256, keyID, err := i.getProviderChecksum(downloadURLs)
 		if err != nil {
 			return PluginMeta{}, err
 		}
@@ -195,6 +233,9 @@ func (i *ProviderInstaller) Get(provider string, req Constraints) (PluginMeta, e
 		if sha256 != "" {
 			providerURL = providerURL + "?checksum=sha256:" + sha256
 		}
+		if keyID != "" {
+			i.Ui.Info(fmt.Sprintf("- Signature for provider %q verified with key ID %s", provider, keyID))
+		}
 	}
 
 	i.Ui.Info(fmt.Sprintf("- Downloading plugin for provider %q (%s)...", provider, versionMeta.Version))
@@ -242,28 +283,56 @@ func (i *ProviderInstaller) Get(provider string, req Constraints) (PluginMeta, e
 }
 
 func (i *ProviderInstaller) install(provider string, version Version, url string) error {
-	if i.Cache != nil {
+	return installFromURL(i.Dir, i.Cache, i.OS, i.Arch, provider, version, url, i.progress())
+}
+
+// installFromURL downloads (or, if cache is non-nil, reuses a cached copy
+// of) the release archive at url and extracts it into dir, linking or
+// copying the cached binary into dir when a cache is in use. It is shared
+// by ProviderInstaller.install and BundleInstaller so that both honor the
+// plugin cache, and any concurrent installer processes that share it, in
+// exactly the same way. progress is reported BytesDownloaded events as the
+// archive is fetched; pass NilProgressListener{} if none is wanted.
+func installFromURL(dir string, cache PluginCache, os_, arch, provider string, version Version, url string, progress ProgressListener) error {
+	if cache != nil {
 		log.Printf("[DEBUG] looking for provider %s %s in plugin cache", provider, version)
-		cached := i.Cache.CachedPluginPath("provider", provider, version)
+		cached := cache.CachedPluginPath("provider", provider, version)
+		if cached == "" {
+			// Two installer workers (whether in this process, as with
+			// GetMany/BatchInstaller, or in another concurrently-running
+			// "terraform init") can race to populate the same cache entry.
+			// Take an on-disk lock, keyed by exactly what determines the
+			// cache entry, so only one of them extracts the archive.
+			lockPath := filepath.Join(cache.InstallDir(), fmt.Sprintf(".%s_%s_%s_%s.lock", provider, version, os_, arch))
+			unlock, err := lockCacheFile(lockPath)
+			if err != nil {
+				return fmt.Errorf("failed to lock plugin cache for %s %s: %s", provider, version, err)
+			}
+			defer unlock()
+
+			// Another worker may have populated the cache while we were
+			// waiting for the lock.
+			cached = cache.CachedPluginPath("provider", provider, version)
+		}
 		if cached == "" {
 			log.Printf("[DEBUG] %s %s not yet in cache, so downloading %s", provider, version, url)
-			err := getter.Get(i.Cache.InstallDir(), url)
+			err := downloadWithProgress(cache.InstallDir(), url, provider, progress)
 			if err != nil {
 				return err
 			}
 			// should now be in cache
-			cached = i.Cache.CachedPluginPath("provider", provider, version)
+			cached = cache.CachedPluginPath("provider", provider, version)
 			if cached == "" {
 				// should never happen if the getter is behaving properly
 				// and the plugins are packaged properly.
-				return fmt.Errorf("failed to find downloaded plugin in cache %s", i.Cache.InstallDir())
+				return fmt.Errorf("failed to find downloaded plugin in cache %s", cache.InstallDir())
 			}
 		}
 
 		// Link or copy the cached binary into our install dir so the
 		// normal resolution machinery can find                                                     it.
 		filename := filepath.Base(cached)
-		targetPath := filepath.Join(i.Dir, filename)
+		targetPath := filepath.Join(dir, filename)
 
 		log.Printf("[DEBUG] installing %s %s to %s from local cache %s", provider, version, targetPath, cached)
 
@@ -321,10 +390,10 @@ func (i *ProviderInstaller) install(provider string, version Version, url string
 		}
 
 		// One way or another, by the time we get here we should have either
-		// a link or a copy of the cached plugin within i.Dir, as expected.
+		// a link or a copy of the cached plugin within dir, as expected.
 	} else {
 		log.Printf("[DEBUG] plugin cache is disabled, so downloading %s %s from %s", provider, version, url)
-		err := getter.Get(i.Dir, url)
+		err := downloadWithProgress(dir, url, provider, progress)
 		if err != nil {
 			return err
 		}
@@ -332,6 +401,46 @@ func (i *ProviderInstaller) install(provider string, version Version, url string
 	return nil
 }
 
+// downloadWithProgress fetches url into dstDir with go-getter, reporting
+// BytesDownloaded events to progress as the archive streams in.
+func downloadWithProgress(dstDir, url, provider string, progress ProgressListener) error {
+	client := &getter.Client{
+		Src:              url,
+		Dst:              dstDir,
+		Pwd:              dstDir,
+		Mode:             getter.ClientModeAny,
+		ProgressListener: &downloadProgressTracker{provider: provider, listener: progress},
+	}
+	return client.Get()
+}
+
+// downloadProgressTracker adapts our ProgressListener to go-getter's
+// ProgressTracker interface, reporting the bytes read from the underlying
+// stream as they're consumed rather than waiting for the whole download (or
+// extraction) to finish.
+type downloadProgressTracker struct {
+	provider string
+	listener ProgressListener
+}
+
+func (t *downloadProgressTracker) TrackProgress(src string, currentSize, totalSize int64, stream io.ReadCloser) io.ReadCloser {
+	return &progressReportingReader{ReadCloser: stream, provider: t.provider, listener: t.listener}
+}
+
+type progressReportingReader struct {
+	io.ReadCloser
+	provider string
+	listener ProgressListener
+}
+
+func (r *progressReportingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.listener.BytesDownloaded(r.provider, int64(n))
+	}
+	return n, err
+}
+
 func (i *ProviderInstaller) PurgeUnused(used map[string]PluginMeta) (PluginMetaSet, error) {
 	purge := make(PluginMetaSet)
 
@@ -364,23 +473,51 @@ func (i *ProviderInstaller) PurgeUnused(used map[string]PluginMeta) (PluginMetaS
 	return removed, errs
 }
 
-func (i *ProviderInstaller) getProviderChecksum(urls *response.TerraformProviderPlatformLocation) (string, error) {
-	checksums, err := getPluginSHA256SUMs(urls.ShasumsURL, urls.ShasumsSignatureURL)
+// getProviderChecksum fetches and verifies the SHA256SUMS file for urls,
+// returning the checksum for urls.Filename plus the ID of the key that
+// validated the signature, so that callers can surface it to the user.
+func (i *ProviderInstaller) getProviderChecksum(urls *response.TerraformProviderPlatformLocation) (sha256sum, keyID string, err error) {
+	verifier, err := i.verifier()
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
-	return checksumForFile(checksums, urls.Filename), nil
+	checksums, keyID, err := i.getPluginSHA256SUMs(urls.ShasumsURL, urls.ShasumsSignatureURL, verifier)
+	if err != nil {
+		return "", "", err
+	}
+
+	return checksumForFile(checksums, urls.Filename), keyID, nil
 }
 
 // list all versions available for the named provider
 func (i *ProviderInstaller) listProviderVersions(name string) (*response.TerraformProviderVersions, error) {
+	for _, source := range i.Sources {
+		versions, err := source.ListProviderVersions(name)
+		if err != nil {
+			return nil, err
+		}
+		if versions != nil && len(versions.Versions) > 0 {
+			return versions, nil
+		}
+	}
+
 	provider := regsrc.NewTerraformProvider(name, i.OS, i.Arch)
 	versions, err := i.registry.TerraformProviderVersions(provider)
 	return versions, err
 }
 
 func (i *ProviderInstaller) listProviderDownloadURLs(name, version string) (*response.TerraformProviderPlatformLocation, error) {
+	for _, source := range i.Sources {
+		urls, err := source.ProviderLocation(name, version)
+		if err != nil {
+			return nil, err
+		}
+		if urls != nil {
+			return urls, nil
+		}
+	}
+
 	urls, err := i.registry.TerraformProviderLocation(regsrc.NewTerraformProvider(name, i.OS, i.Arch), version)
 	if urls == nil {
 		return nil, fmt.Errorf("No download urls found for provider %s", i.provider)
@@ -419,15 +556,88 @@ func (i *ProviderInstaller) checkPluginProtocol(versionMeta *response.TerraformP
 			return nil
 		}
 	}
-	// TODO: get most recent compatible plugin and return a handy-dandy string for the user
-	// latest, err := getNewestCompatiblePlugin
-	// i.Ui.output|info): "the latest version of plugin BLAH which supports protocol BLAH is BLAH"
-	// Add this to your provider block:
-	// version = ~BLAH
-	// and if none is found, return ErrorNoVersionCompatible
 	return ErrorNoVersionCompatible
 }
 
+// resolveCompatibleVersion walks versions, which must already be sorted
+// newest to oldest, looking for the newest one that is compatible with
+// both i.PluginProtocolVersion and i.OS/i.Arch. It returns that version
+// along with its download location.
+//
+// If a version is protocol-compatible but no version at all also has a
+// matching platform, resolveCompatibleVersion still returns the newest
+// protocol-compatible version as newestProtoCompatible (with a nil
+// location) so the caller can build a helpful diagnostic; the returned
+// error in that case is ErrorNoVersionCompatibleWithPlatform. If no
+// version is even protocol-compatible, newestProtoCompatible is nil and
+// the error is ErrorNoVersionCompatible.
+func (i *ProviderInstaller) resolveCompatibleVersion(provider string, versions []*response.TerraformProviderVersion) (versionMeta *response.TerraformProviderVersion, downloadURLs *response.TerraformProviderPlatformLocation, newestProtoCompatible *response.TerraformProviderVersion, err error) {
+	for _, candidate := range versions {
+		if err := i.checkPluginProtocol(candidate); err != nil {
+			continue
+		}
+		if newestProtoCompatible == nil {
+			newestProtoCompatible = candidate
+		}
+		if !platformSupported(candidate, i.OS, i.Arch) {
+			continue
+		}
+
+		urls, err := i.listProviderDownloadURLs(provider, candidate.Version)
+		if err != nil {
+			return nil, nil, newestProtoCompatible, err
+		}
+		return candidate, urls, newestProtoCompatible, nil
+	}
+
+	if newestProtoCompatible != nil {
+		return nil, nil, newestProtoCompatible, ErrorNoVersionCompatibleWithPlatform
+	}
+	return nil, nil, nil, ErrorNoVersionCompatible
+}
+
+// platformSupported reports whether versionMeta lists a platform entry
+// matching the given os/arch.
+func platformSupported(versionMeta *response.TerraformProviderVersion, os, arch string) bool {
+	for _, p := range versionMeta.Platforms {
+		if p.OS == os && p.Arch == arch {
+			return true
+		}
+	}
+	return false
+}
+
+// compatibilityError turns ErrorNoVersionCompatibleWithPlatform from
+// resolveCompatibleVersion into a diagnostic that names the newest version
+// that was at least protocol-compatible and suggests a version constraint
+// the user can pin to. Every other error, including ErrorNoVersionCompatible
+// and e.g. a network failure while looking up download URLs, is passed
+// through unchanged.
+func compatibilityError(provider, os, arch string, newestCompatible *response.TerraformProviderVersion, err error) error {
+	if err != ErrorNoVersionCompatibleWithPlatform || newestCompatible == nil {
+		return err
+	}
+
+	return fmt.Errorf(
+		"no version of provider %q is compatible with both your version of Terraform and the %s_%s platform; "+
+			"the newest version compatible with your version of Terraform is %s, but it is not published for %s_%s. "+
+			"To use it anyway, add the following to your provider configuration:\n\n    version = \"~> %s\"",
+		provider, os, arch, newestCompatible.Version, os, arch, minorVersionConstraint(newestCompatible.Version),
+	)
+}
+
+// minorVersionConstraint returns the "major.minor" prefix of a dotted
+// version string, suitable for suggesting a "~> X.Y" constraint that
+// tracks patch releases of the given version rather than pinning to it
+// exactly.
+func minorVersionConstraint(version string) string {
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		return version
+	}
+	return parts[0] + "." + parts[1]
+}
+
 func (i *ProviderInstaller) setProvider(p string) {
 	if i.provider == "" {
 		i.provider = p
@@ -462,27 +672,54 @@ func checksumForFile(sums []byte, name string) string {
 	return ""
 }
 
-// fetch the SHA256SUMS file provided, and verify its signature.
-func getPluginSHA256SUMs(sumsURL, sigURL string) ([]byte, error) {
+// getPluginSHA256SUMs fetches the SHA256SUMS file provided, verifies its
+// signature using verifier, and returns both the checksums file contents
+// and the ID of the key that validated it.
+func (i *ProviderInstaller) getPluginSHA256SUMs(sumsURL, sigURL string, verifier SignatureVerifier) ([]byte, string, error) {
 	sums, err := getFile(sumsURL)
 	if err != nil {
-		return nil, fmt.Errorf("error fetching checksums: %s", err)
+		return nil, "", fmt.Errorf("error fetching checksums: %s", err)
 	}
 
 	sig, err := getFile(sigURL)
 	if err != nil {
-		return nil, fmt.Errorf("error fetching checksums signature: %s", err)
+		return nil, "", fmt.Errorf("error fetching checksums signature: %s", err)
 	}
 
-	if err := verifySig(sums, sig); err != nil {
-		return nil, err
+	keyID, err := verifier.Verify(sums, sig, providerNamespace(i.provider))
+	if err != nil {
+		return nil, "", &errVerificationFailed{err}
 	}
 
-	return sums, nil
+	return sums, keyID, nil
 }
 
-func getFile(url string) ([]byte, error) {
-	resp, err := httpClient.Get(url)
+// errVerificationFailed wraps a provider release's checksum/signature
+// verification failure so that isTransientInstallError can recognize it as
+// a permanent error: a tampered or corrupted download should never be
+// retried, no matter how it's retried.
+type errVerificationFailed struct {
+	err error
+}
+
+func (e *errVerificationFailed) Error() string {
+	return e.err.Error()
+}
+
+// getFile fetches the content at rawURL, which is usually an http(s) URL
+// served by the registry but may also be a file:// URL, as constructed by
+// FilesystemSource, so that a filesystem provider mirror can supply its
+// SHA256SUMS and signature the same way as the registry does.
+func getFile(rawURL string) ([]byte, error) {
+	if strings.HasPrefix(rawURL, "file://") {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid file URL %q: %s", rawURL, err)
+		}
+		return ioutil.ReadFile(filepath.FromSlash(u.Path))
+	}
+
+	resp, err := httpClient.Get(rawURL)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,275 @@
+package discovery
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// defaultGetManyConcurrency bounds the number of providers that GetMany
+// will resolve and download at once, so that a config with many pinned
+// providers doesn't open an unbounded number of simultaneous connections
+// to the registry.
+const defaultGetManyConcurrency = 10
+
+// getManyMaxRetries is the number of additional attempts GetMany will make
+// for a single provider after a transient failure before giving up on it.
+const getManyMaxRetries = 3
+
+// getManyRetryBaseDelay is the initial backoff delay between retries. Each
+// subsequent retry doubles this delay.
+const getManyRetryBaseDelay = 500 * time.Millisecond
+
+// InstallErrors collects the per-provider errors produced by a GetMany
+// call. Providers that installed successfully are simply absent from the
+// map, so callers can check len(errs) == 0 to know everything succeeded.
+type InstallErrors map[string]error
+
+func (e InstallErrors) Error() string {
+	if len(e) == 0 {
+		return "no errors"
+	}
+	names := make([]string, 0, len(e))
+	for name := range e {
+		names = append(names, name)
+	}
+	return fmt.Sprintf("failed to install %d provider(s): %v", len(e), names)
+}
+
+// ProgressListener receives structured events describing the progress of a
+// GetMany call, so that a caller (such as the "terraform init" progress UI)
+// can render richer feedback than the plain log lines written to Ui.
+//
+// Implementations must be safe to call concurrently, since events for
+// multiple providers may be reported from different workers at once.
+type ProgressListener interface {
+	// Start is called once resolution begins for a provider. version is
+	// the requested version constraint, since the exact version is not yet
+	// known at that point.
+	Start(provider string, version string)
+
+	// BytesDownloaded is called periodically while a provider's release
+	// archive is being downloaded.
+	BytesDownloaded(provider string, bytes int64)
+
+	// Verified is called once a provider's checksum and signature have
+	// been checked successfully. It is not called when verification is
+	// disabled (SkipVerify).
+	Verified(provider string)
+
+	// Installed is called once a provider has been extracted into the
+	// installer's target directory.
+	Installed(provider string, meta PluginMeta)
+
+	// Failed is called if a provider could not be installed, after all
+	// retries have been exhausted.
+	Failed(provider string, err error)
+}
+
+// NilProgressListener is a ProgressListener that discards all events. It is
+// used as the default when no listener is configured.
+type NilProgressListener struct{}
+
+func (NilProgressListener) Start(provider, version string)            {}
+func (NilProgressListener) BytesDownloaded(provider string, n int64)  {}
+func (NilProgressListener) Verified(provider string)                  {}
+func (NilProgressListener) Installed(provider string, meta PluginMeta) {}
+func (NilProgressListener) Failed(provider string, err error)         {}
+
+func (i *ProviderInstaller) progress() ProgressListener {
+	if i.Progress != nil {
+		return i.Progress
+	}
+	return NilProgressListener{}
+}
+
+// GetMany resolves and downloads several providers concurrently, bounded by
+// Concurrency workers (or defaultGetManyConcurrency if that is unset). Each
+// provider is retried independently with exponential backoff if it fails
+// with what looks like a transient error (e.g. a registry 5xx or a network
+// timeout); resolution failures like ErrorNoSuchProvider are not retried.
+//
+// GetMany aggregates all installed plugins into the returned PluginMetaSet
+// and all failures (after retries are exhausted) into the returned
+// InstallErrors, so that one bad provider does not prevent the others from
+// installing. Progress for each provider is reported through the
+// installer's configured ProgressListener, and Ui output is serialized so
+// that lines from concurrent workers are not interleaved.
+func (i *ProviderInstaller) GetMany(reqs map[string]Constraints) (PluginMetaSet, InstallErrors) {
+	concurrency := i.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultGetManyConcurrency
+	}
+
+	type result struct {
+		name string
+		meta PluginMeta
+		err  error
+	}
+
+	work := make(chan string, len(reqs))
+	results := make(chan result, len(reqs))
+	var uiLock sync.Mutex
+
+	worker := func() {
+		for name := range work {
+			req := reqs[name]
+			meta, err := i.getWithRetry(name, req, &uiLock)
+			results <- result{name: name, meta: meta, err: err}
+		}
+	}
+
+	numWorkers := concurrency
+	if numWorkers > len(reqs) {
+		numWorkers = len(reqs)
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < numWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			worker()
+		}()
+	}
+
+	for name := range reqs {
+		work <- name
+	}
+	close(work)
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	metas := make(PluginMetaSet)
+	errs := make(InstallErrors)
+	for res := range results {
+		if res.err != nil {
+			errs[res.name] = res.err
+			i.progress().Failed(res.name, res.err)
+			continue
+		}
+		metas.Add(res.meta)
+	}
+
+	if len(errs) == 0 {
+		return metas, nil
+	}
+	return metas, errs
+}
+
+// getWithRetry wraps getOne (via lockedGet) with retry/backoff for errors
+// that look transient, and serializes the interleaved Ui output from
+// concurrent workers via uiLock.
+func (i *ProviderInstaller) getWithRetry(name string, req Constraints, uiLock *sync.Mutex) (PluginMeta, error) {
+	i.progress().Start(name, fmt.Sprintf("%v", req))
+
+	var lastErr error
+	delay := getManyRetryBaseDelay
+	for attempt := 0; attempt <= getManyMaxRetries; attempt++ {
+		if attempt > 0 {
+			log.Printf("[DEBUG] retrying install of %s (attempt %d) after: %s", name, attempt+1, lastErr)
+			time.Sleep(delay)
+			delay *= 2
+		}
+
+		meta, err := i.lockedGet(name, req, uiLock)
+		if err == nil {
+			if !i.SkipVerify {
+				i.progress().Verified(name)
+			}
+			i.progress().Installed(name, meta)
+			return meta, nil
+		}
+
+		lastErr = err
+		if !isTransientInstallError(err) {
+			return PluginMeta{}, err
+		}
+	}
+
+	return PluginMeta{}, fmt.Errorf("failed to install %s after %d attempts: %s", name, getManyMaxRetries+1, lastErr)
+}
+
+// lockedGet runs getOne with a Ui whose every call is serialized through
+// uiLock, so that the underlying cli.Ui, which is not safe for concurrent
+// writes, never receives interleaved output from two providers at once.
+// The calls to getOne themselves are not serialized: the network I/O that
+// makes up the bulk of their work runs concurrently, outside of uiLock.
+func (i *ProviderInstaller) lockedGet(name string, req Constraints, uiLock *sync.Mutex) (PluginMeta, error) {
+	// Each provider gets its own *ProviderInstaller so that per-call state
+	// such as i.provider does not race between workers; everything else is
+	// shared configuration and is safe to read concurrently.
+	sub := *i
+	sub.Ui = &serializedUi{ui: i.Ui, lock: uiLock}
+	sub.provider = ""
+	return sub.getOne(name, req)
+}
+
+// isTransientInstallError reports whether err looks like the kind of
+// transient failure (network error, registry 5xx, timeout) that is worth
+// retrying, as opposed to a permanent resolution failure such as
+// ErrorNoSuchProvider or ErrorNoVersionCompatible, or a checksum/signature
+// verification failure indicating a tampered or corrupted download.
+func isTransientInstallError(err error) bool {
+	switch err {
+	case ErrorNoSuchProvider, ErrorNoSuitableVersion, ErrorNoVersionCompatible, ErrorNoVersionCompatibleWithPlatform:
+		return false
+	}
+	if _, ok := err.(*errVerificationFailed); ok {
+		return false
+	}
+	return true
+}
+
+// serializedUi wraps a cli.Ui so that concurrent GetMany workers don't
+// interleave their output.
+type serializedUi struct {
+	ui interface {
+		Info(string)
+		Output(string)
+		Warn(string)
+		Error(string)
+		Ask(string) (string, error)
+		AskSecret(string) (string, error)
+	}
+	lock *sync.Mutex
+}
+
+func (u *serializedUi) Info(s string) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	u.ui.Info(s)
+}
+
+func (u *serializedUi) Output(s string) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	u.ui.Output(s)
+}
+
+func (u *serializedUi) Warn(s string) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	u.ui.Warn(s)
+}
+
+func (u *serializedUi) Error(s string) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	u.ui.Error(s)
+}
+
+func (u *serializedUi) Ask(s string) (string, error) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	return u.ui.Ask(s)
+}
+
+func (u *serializedUi) AskSecret(s string) (string, error) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	return u.ui.AskSecret(s)
+}
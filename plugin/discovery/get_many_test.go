@@ -0,0 +1,233 @@
+package discovery
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform/registry"
+	"github.com/mitchellh/cli"
+)
+
+var genericArchiveRe = regexp.MustCompile(`terraform-provider-([a-z0-9-]+)_(\d).(\d).(\d)_([^_]+)_([^._]+).zip`)
+
+// genericTestServer serves version listings and download archives for any
+// provider name matching the terraform-provider-<name> convention, calling
+// onRequest (if non-nil) before serving each archive download so tests can
+// inject delays, failures, or concurrency tracking.
+func genericTestServer(onRequest func(provider string) error) *httptest.Server {
+	handler := http.NewServeMux()
+
+	handler.HandleFunc("/v1/providers/terraform-providers/", func(w http.ResponseWriter, r *http.Request) {
+		if name := listingProviderName(r.URL.Path); name != "" {
+			list := versionList
+			list.ID = name
+			serveJSON(w, list)
+			return
+		}
+
+		m := genericArchiveRe.FindStringSubmatch(r.URL.Path)
+		if len(m) != 7 {
+			http.Error(w, "invalid provider: "+r.URL.Path, http.StatusNotFound)
+			return
+		}
+		provider := m[1]
+
+		if onRequest != nil {
+			if err := onRequest(provider); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		w.Header().Set(protocolVersionHeader, m[4])
+		z := zip.NewWriter(w)
+		fn := fmt.Sprintf("terraform-provider-%s_v%s.%s.%s_x%s", provider, m[2], m[3], m[4], m[4])
+		f, err := z.Create(fn)
+		if err != nil {
+			panic(err)
+		}
+		io.WriteString(f, testProviderFile)
+		z.Close()
+	})
+
+	handler.HandleFunc("/.well-known/terraform.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"modules.v1":"http://localhost/v1/modules/", "providers.v1":"http://localhost/v1/providers/"}`)
+	})
+
+	return httptest.NewServer(handler)
+}
+
+// listingProviderName returns the provider name if path looks like a
+// version-listing request (".../terraform-providers/<name>/"), or "" if it
+// looks like a download request instead.
+func listingProviderName(path string) string {
+	const prefix = "/v1/providers/terraform-providers/"
+	if len(path) <= len(prefix) {
+		return ""
+	}
+	rest := path[len(prefix):]
+	if len(rest) > 0 && rest[len(rest)-1] == '/' {
+		return rest[:len(rest)-1]
+	}
+	return ""
+}
+
+func serveJSON(w http.ResponseWriter, v interface{}) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(js)
+}
+
+// TestGetManyRetriesTransientFailures drives a test server that fails the
+// first two download attempts with a 500 before succeeding, and verifies
+// that GetMany retries rather than giving up immediately.
+func TestGetManyRetriesTransientFailures(t *testing.T) {
+	var attempts int32
+	server := genericTestServer(func(provider string) error {
+		if atomic.AddInt32(&attempts, 1) <= 2 {
+			return fmt.Errorf("induced failure")
+		}
+		return nil
+	})
+	defer server.Close()
+
+	tmpDir, err := ioutil.TempDir("", "tf-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	i := &ProviderInstaller{
+		Dir: tmpDir,
+		PluginProtocolVersion: 3,
+		SkipVerify:            true,
+		Ui:                    cli.NewMockUi(),
+		registry:              registry.NewClient(Disco(server), nil, nil),
+	}
+
+	metas, errs := i.GetMany(map[string]Constraints{"test": AllVersions})
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %#v", errs)
+	}
+	if metas.Count() != 1 {
+		t.Fatalf("expected one installed provider, got %d", metas.Count())
+	}
+	if atomic.LoadInt32(&attempts) != 3 {
+		t.Fatalf("expected 3 attempts (2 failures + 1 success), got %d", attempts)
+	}
+}
+
+// TestGetManyRetriesExhausted verifies that GetMany gives up and reports a
+// per-provider error once retries are exhausted against a server that
+// never recovers.
+func TestGetManyRetriesExhausted(t *testing.T) {
+	server := genericTestServer(func(provider string) error {
+		return fmt.Errorf("induced failure")
+	})
+	defer server.Close()
+
+	tmpDir, err := ioutil.TempDir("", "tf-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	i := &ProviderInstaller{
+		Dir: tmpDir,
+		PluginProtocolVersion: 3,
+		SkipVerify:            true,
+		Ui:                    cli.NewMockUi(),
+		registry:              registry.NewClient(Disco(server), nil, nil),
+	}
+
+	_, errs := i.GetMany(map[string]Constraints{"test": AllVersions})
+	if err, ok := errs["test"]; !ok || err == nil {
+		t.Fatalf("expected an error for provider %q, got %#v", "test", errs)
+	}
+}
+
+// TestIsTransientInstallErrorVerificationFailure verifies that a checksum
+// or signature verification failure is never treated as transient, since
+// retrying it would just mean fetching the same tampered or corrupted
+// release again.
+func TestIsTransientInstallErrorVerificationFailure(t *testing.T) {
+	err := &errVerificationFailed{fmt.Errorf("signature does not match any trusted key")}
+	if isTransientInstallError(err) {
+		t.Fatal("expected a verification failure to be treated as permanent, not transient")
+	}
+}
+
+// TestGetManyConcurrent verifies that several distinct providers are
+// downloaded concurrently rather than serially, by having the server
+// record how many downloads are in flight at once.
+func TestGetManyConcurrent(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		inFlCnt int
+		maxSeen int
+	)
+
+	server := genericTestServer(func(provider string) error {
+		mu.Lock()
+		inFlCnt++
+		if inFlCnt > maxSeen {
+			maxSeen = inFlCnt
+		}
+		mu.Unlock()
+
+		time.Sleep(50 * time.Millisecond)
+
+		mu.Lock()
+		inFlCnt--
+		mu.Unlock()
+		return nil
+	})
+	defer server.Close()
+
+	tmpDir, err := ioutil.TempDir("", "tf-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	providers := []string{"test-a", "test-b", "test-c", "test-d"}
+
+	i := &ProviderInstaller{
+		Dir: tmpDir,
+		PluginProtocolVersion: 3,
+		SkipVerify:            true,
+		Ui:                    cli.NewMockUi(),
+		registry:              registry.NewClient(Disco(server), nil, nil),
+		Concurrency:           len(providers),
+	}
+
+	reqs := map[string]Constraints{}
+	for _, name := range providers {
+		reqs[name] = AllVersions
+	}
+	_, errs := i.GetMany(reqs)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %#v", errs)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if maxSeen < 2 {
+		t.Fatalf("expected concurrent downloads, max in-flight was %d", maxSeen)
+	}
+}
@@ -364,7 +364,7 @@ func TestProviderChecksum(t *testing.T) {
 	i := ProviderInstaller{}
 
 	for _, test := range tests {
-		sha256sum, err := i.getProviderChecksum(test.URLs)
+		sha256sum, _, err := i.getProviderChecksum(test.URLs)
 		if err != nil {
 			t.Fatal(err)
 		}
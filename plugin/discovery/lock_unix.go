@@ -0,0 +1,30 @@
+// +build !windows
+
+package discovery
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockCacheFile takes an exclusive, advisory lock on path, creating it if
+// necessary, and returns a function that releases the lock. It blocks
+// until the lock is available, so that two concurrent installer workers
+// (whether in this process or another) serialize on the same provider
+// archive rather than both extracting it into the shared cache at once.
+func lockCacheFile(path string) (unlock func() error, err error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return func() error {
+		defer f.Close()
+		return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	}, nil
+}
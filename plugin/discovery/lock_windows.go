@@ -0,0 +1,33 @@
+// +build windows
+
+package discovery
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockCacheFile takes an exclusive, advisory lock on path, creating it if
+// necessary, and returns a function that releases the lock. It blocks
+// until the lock is available, so that two concurrent installer workers
+// (whether in this process or another) serialize on the same provider
+// archive rather than both extracting it into the shared cache at once.
+func lockCacheFile(path string) (unlock func() error, err error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	ol := new(syscall.Overlapped)
+	const lockfileExclusiveLock = 0x00000002
+	if err := syscall.LockFileEx(syscall.Handle(f.Fd()), lockfileExclusiveLock, 0, 1, 0, ol); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return func() error {
+		defer f.Close()
+		ol := new(syscall.Overlapped)
+		return syscall.UnlockFileEx(syscall.Handle(f.Fd()), 0, 1, 0, ol)
+	}, nil
+}
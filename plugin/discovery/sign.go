@@ -0,0 +1,270 @@
+package discovery
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/errors"
+
+	"github.com/hashicorp/terraform/registry/regsrc"
+	"github.com/hashicorp/terraform/svchost"
+	"github.com/hashicorp/terraform/svchost/disco"
+)
+
+// SignatureVerifier checks a provider release's SHA256SUMS file against its
+// detached signature. Implementations may trust different keys for
+// different provider namespaces (e.g. official HashiCorp providers vs.
+// community providers), and should return the ID of whichever trusted key
+// actually validated the signature so that callers can surface it to the
+// user.
+type SignatureVerifier interface {
+	Verify(sums, sig []byte, providerNamespace string) (keyID string, err error)
+}
+
+// GPGSignatureVerifier is the default SignatureVerifier. It checks a
+// detached OpenPGP signature against a keyring assembled from the embedded
+// HashiCorp release key plus any additional per-namespace and ambient
+// trusted keys that have been configured.
+type GPGSignatureVerifier struct {
+	// Keyring is consulted for every namespace in addition to any
+	// namespace-specific keys in NamespaceKeyrings. It is what
+	// NewGPGSignatureVerifier seeds with the embedded HashiCorp key.
+	Keyring openpgp.EntityList
+
+	// NamespaceKeyrings holds additional keys that are trusted only for
+	// providers published under a specific registry namespace, such as
+	// "terraform-providers" or a community publisher's own namespace.
+	NamespaceKeyrings map[string]openpgp.EntityList
+}
+
+// NewGPGSignatureVerifier returns a GPGSignatureVerifier whose keyring is
+// seeded with the embedded HashiCorp release key, suitable as a starting
+// point for a ProviderInstaller's default Verifier.
+func NewGPGSignatureVerifier() (*GPGSignatureVerifier, error) {
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader([]byte(HashicorpPublicKey)))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing embedded HashiCorp public key: %s", err)
+	}
+	return &GPGSignatureVerifier{Keyring: keyring}, nil
+}
+
+// TrustKeyForNamespace adds the given ASCII-armored public key(s) to the
+// set trusted for providers published under the given registry namespace.
+func (v *GPGSignatureVerifier) TrustKeyForNamespace(namespace string, armoredKey []byte) error {
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(armoredKey))
+	if err != nil {
+		return fmt.Errorf("error parsing public key for namespace %q: %s", namespace, err)
+	}
+	if v.NamespaceKeyrings == nil {
+		v.NamespaceKeyrings = make(map[string]openpgp.EntityList)
+	}
+	v.NamespaceKeyrings[namespace] = append(v.NamespaceKeyrings[namespace], keyring...)
+	return nil
+}
+
+// TrustAmbientKey adds the given ASCII-armored public key(s) to the
+// ambient Keyring, trusted for every namespace.
+func (v *GPGSignatureVerifier) TrustAmbientKey(armoredKey []byte) error {
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(armoredKey))
+	if err != nil {
+		return fmt.Errorf("error parsing public key: %s", err)
+	}
+	v.Keyring = append(v.Keyring, keyring...)
+	return nil
+}
+
+// TrustKeysInDir reads every file in dir as an ASCII-armored public key (or
+// keyring) and adds it to the ambient Keyring, so that users can drop
+// their own trusted keys alongside the embedded HashiCorp key without
+// having to fork the installer. It is not an error for dir to not exist;
+// that simply means no additional keys are trusted.
+func (v *GPGSignatureVerifier) TrustKeysInDir(dir string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading trusted key directory %s: %s", dir, err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return fmt.Errorf("error reading trusted key %s: %s", entry.Name(), err)
+		}
+		if err := v.TrustAmbientKey(data); err != nil {
+			return fmt.Errorf("error loading trusted key %s: %s", entry.Name(), err)
+		}
+	}
+	return nil
+}
+
+// discoSigningKeysDoc is the document shape expected at the
+// "providers.signing-keys.v1" service advertised by a host's discovery
+// document, listing the ASCII-armored keys trusted for each provider
+// namespace hosted there.
+type discoSigningKeysDoc struct {
+	Namespaces map[string][]string `json:"namespaces"`
+}
+
+// TrustKeysFromDiscovery populates NamespaceKeyrings from the
+// "providers.signing-keys.v1" service advertised in hostname's discovery
+// document, if any, registered with services. This lets a private
+// registry publish the keys it signs community/third-party providers with
+// instead of requiring every caller to carry them out of band.
+func (v *GPGSignatureVerifier) TrustKeysFromDiscovery(services *disco.Disco, hostname string) error {
+	host, err := svchost.ForComparison(hostname)
+	if err != nil {
+		return fmt.Errorf("invalid hostname %q: %s", hostname, err)
+	}
+
+	url := services.DiscoverServiceURL(host, "providers.signing-keys.v1")
+	if url == nil {
+		// This host doesn't advertise any namespace-specific signing
+		// keys, which is fine; callers still fall back to the ambient
+		// Keyring.
+		return nil
+	}
+
+	body, err := getFile(url.String())
+	if err != nil {
+		return fmt.Errorf("error fetching signing keys from %s: %s", hostname, err)
+	}
+
+	var doc discoSigningKeysDoc
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return fmt.Errorf("error parsing signing keys document from %s: %s", hostname, err)
+	}
+
+	for namespace, armoredKeys := range doc.Namespaces {
+		for _, armoredKey := range armoredKeys {
+			if err := v.TrustKeyForNamespace(namespace, []byte(armoredKey)); err != nil {
+				return fmt.Errorf("error loading signing key for namespace %q from %s: %s", namespace, hostname, err)
+			}
+		}
+	}
+	return nil
+}
+
+// providerNamespace extracts the registry namespace from a provider
+// address of the form "namespace/type", falling back to the default
+// "terraform-providers" namespace used for unqualified provider names
+// like "aws".
+func providerNamespace(provider string) string {
+	if idx := strings.IndexByte(provider, '/'); idx >= 0 {
+		return provider[:idx]
+	}
+	return "terraform-providers"
+}
+
+// Verify implements SignatureVerifier. It tries every key trusted for the
+// given namespace, followed by every key in the ambient Keyring, returning
+// the ID of the first key whose signature validates and whose validity
+// window (if any) covers the current time. Any one valid signature from a
+// trusted, non-expired, non-revoked key is sufficient.
+func (v *GPGSignatureVerifier) Verify(sums, sig []byte, providerNamespace string) (string, error) {
+	var tried []string
+
+	candidates := append(openpgp.EntityList{}, v.NamespaceKeyrings[providerNamespace]...)
+	candidates = append(candidates, v.Keyring...)
+
+	for _, entity := range candidates {
+		signer, err := openpgp.CheckDetachedSignature(openpgp.EntityList{entity}, bytes.NewReader(sums), bytes.NewReader(sig))
+		if err == errors.ErrUnknownIssuer {
+			continue
+		}
+		keyID := entityKeyID(entity)
+		if err != nil {
+			tried = append(tried, keyID)
+			continue
+		}
+		if expired, when := keyExpired(signer); expired {
+			tried = append(tried, fmt.Sprintf("%s (expired %s)", keyID, when.Format(time.RFC3339)))
+			continue
+		}
+		if len(signer.Revocations) > 0 {
+			tried = append(tried, fmt.Sprintf("%s (revoked)", keyID))
+			continue
+		}
+		return keyID, nil
+	}
+
+	if len(tried) == 0 {
+		return "", fmt.Errorf("signature does not match any trusted key for namespace %q", providerNamespace)
+	}
+	return "", fmt.Errorf("signature not valid under any trusted key for namespace %q (tried: %v)", providerNamespace, tried)
+}
+
+// keyExpired reports whether any of entity's identities has a
+// self-signature whose key lifetime has run out, along with the expiry
+// time if so.
+func keyExpired(entity *openpgp.Entity) (bool, time.Time) {
+	for _, ident := range entity.Identities {
+		if ident.SelfSignature == nil || ident.SelfSignature.KeyLifetimeSecs == nil {
+			continue
+		}
+		expiry := entity.PrimaryKey.CreationTime.Add(time.Duration(*ident.SelfSignature.KeyLifetimeSecs) * time.Second)
+		if time.Now().After(expiry) {
+			return true, expiry
+		}
+	}
+	return false, time.Time{}
+}
+
+func entityKeyID(entity *openpgp.Entity) string {
+	if entity == nil || entity.PrimaryKey == nil {
+		return "unknown"
+	}
+	return entity.PrimaryKey.KeyIdString()
+}
+
+// verifier returns the installer's configured SignatureVerifier, lazily
+// initializing the default GPG-based one if none has been set. The
+// default trusts the embedded HashiCorp key for every namespace, plus
+// whatever namespace-specific keys i.Services' discovery metadata
+// advertises for the registry host, plus any user-supplied keys found in
+// i.KeyDir.
+func (i *ProviderInstaller) verifier() (SignatureVerifier, error) {
+	if i.Verifier != nil {
+		return i.Verifier, nil
+	}
+
+	v, err := NewGPGSignatureVerifier()
+	if err != nil {
+		return nil, err
+	}
+
+	if i.Services != nil {
+		// TODO: once provider addresses carry their source registry host
+		// (rather than always being resolved against the public
+		// registry), trust keys from that host instead of always the
+		// public one.
+		if err := v.TrustKeysFromDiscovery(i.Services, regsrc.PublicRegistryHost.String()); err != nil {
+			log.Printf("[WARN] error loading provider signing keys from registry discovery: %s", err)
+		}
+	}
+
+	if i.KeyDir != "" {
+		if err := v.TrustKeysInDir(i.KeyDir); err != nil {
+			return nil, err
+		}
+	}
+
+	i.Verifier = v
+	return v, nil
+}
+
+// HashicorpPublicKey, the ASCII-armored HashiCorp release signing key
+// trusted by default for official providers, is defined alongside the rest
+// of the release signing machinery in signature.go.
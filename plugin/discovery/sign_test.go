@@ -0,0 +1,206 @@
+package discovery
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+func generateTestKey(t *testing.T, expires time.Duration) *openpgp.Entity {
+	t.Helper()
+
+	entity, err := openpgp.NewEntity("test signer", "", "test@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expires > 0 {
+		secs := uint32(expires / time.Second)
+		for _, ident := range entity.Identities {
+			ident.SelfSignature.KeyLifetimeSecs = &secs
+		}
+	}
+
+	return entity
+}
+
+func detachSign(t *testing.T, entity *openpgp.Entity, data []byte) []byte {
+	t.Helper()
+
+	var sig bytes.Buffer
+	if err := openpgp.DetachSign(&sig, entity, bytes.NewReader(data), &packet.Config{}); err != nil {
+		t.Fatal(err)
+	}
+	return sig.Bytes()
+}
+
+func TestGPGSignatureVerifierValid(t *testing.T) {
+	key := generateTestKey(t, 0)
+	sums := []byte("deadbeef  terraform-provider-test_1.2.3_linux_amd64.zip\n")
+	sig := detachSign(t, key, sums)
+
+	v := &GPGSignatureVerifier{Keyring: openpgp.EntityList{key}}
+	keyID, err := v.Verify(sums, sig, "terraform-providers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyID != entityKeyID(key) {
+		t.Fatalf("wrong key id: got %s want %s", keyID, entityKeyID(key))
+	}
+}
+
+// TestGPGSignatureVerifierRotatedKey ensures that a keyring containing both
+// an old and a newly rotated-in key accepts a signature from the new key.
+func TestGPGSignatureVerifierRotatedKey(t *testing.T) {
+	oldKey := generateTestKey(t, 0)
+	newKey := generateTestKey(t, 0)
+
+	sums := []byte("deadbeef  terraform-provider-test_1.2.3_linux_amd64.zip\n")
+	sig := detachSign(t, newKey, sums)
+
+	v := &GPGSignatureVerifier{Keyring: openpgp.EntityList{oldKey, newKey}}
+	keyID, err := v.Verify(sums, sig, "terraform-providers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyID != entityKeyID(newKey) {
+		t.Fatalf("wrong key id: got %s want %s", keyID, entityKeyID(newKey))
+	}
+}
+
+func TestGPGSignatureVerifierExpiredKey(t *testing.T) {
+	key := generateTestKey(t, 1*time.Second)
+
+	sums := []byte("deadbeef  terraform-provider-test_1.2.3_linux_amd64.zip\n")
+	sig := detachSign(t, key, sums)
+
+	time.Sleep(2 * time.Second)
+
+	v := &GPGSignatureVerifier{Keyring: openpgp.EntityList{key}}
+	if _, err := v.Verify(sums, sig, "terraform-providers"); err == nil {
+		t.Fatal("expected error for expired key, got nil")
+	}
+}
+
+func TestGPGSignatureVerifierUnknownSigner(t *testing.T) {
+	signer := generateTestKey(t, 0)
+	other := generateTestKey(t, 0)
+
+	sums := []byte("deadbeef  terraform-provider-test_1.2.3_linux_amd64.zip\n")
+	sig := detachSign(t, signer, sums)
+
+	v := &GPGSignatureVerifier{Keyring: openpgp.EntityList{other}}
+	if _, err := v.Verify(sums, sig, "terraform-providers"); err == nil {
+		t.Fatal("expected error for unknown signer, got nil")
+	}
+}
+
+// TestGPGSignatureVerifierNamespaceTrust verifies that a key trusted only
+// for a specific namespace is not accepted for a different one, and that
+// any single valid key in a multi-key keyring is sufficient.
+func TestGPGSignatureVerifierNamespaceTrust(t *testing.T) {
+	communityKey := generateTestKey(t, 0)
+	officialKey := generateTestKey(t, 0)
+
+	sums := []byte("deadbeef  terraform-provider-community_1.0.0_linux_amd64.zip\n")
+	sig := detachSign(t, communityKey, sums)
+
+	v := &GPGSignatureVerifier{
+		Keyring: openpgp.EntityList{officialKey},
+		NamespaceKeyrings: map[string]openpgp.EntityList{
+			"community-namespace": {communityKey},
+		},
+	}
+
+	if _, err := v.Verify(sums, sig, "terraform-providers"); err == nil {
+		t.Fatal("expected error: community key should not be trusted for the official namespace")
+	}
+
+	keyID, err := v.Verify(sums, sig, "community-namespace")
+	if err != nil {
+		t.Fatalf("expected community key to be trusted for its own namespace: %s", err)
+	}
+	if keyID != entityKeyID(communityKey) {
+		t.Fatalf("wrong key id: got %s want %s", keyID, entityKeyID(communityKey))
+	}
+}
+
+// TestNewGPGSignatureVerifier guards against the embedded HashiCorp public
+// key becoming malformed or truncated, which would otherwise only surface
+// at runtime as a verifier() failure on every non-SkipVerify install.
+func TestNewGPGSignatureVerifier(t *testing.T) {
+	v, err := NewGPGSignatureVerifier()
+	if err != nil {
+		t.Fatalf("embedded HashiCorp public key failed to parse: %s", err)
+	}
+	if len(v.Keyring) == 0 {
+		t.Fatal("expected the embedded HashiCorp public key to be loaded into the keyring")
+	}
+}
+
+func TestProviderNamespace(t *testing.T) {
+	tests := map[string]string{
+		"aws":                     "terraform-providers",
+		"terraform-providers/aws": "terraform-providers",
+		"someuser/widget":         "someuser",
+	}
+	for provider, want := range tests {
+		if got := providerNamespace(provider); got != want {
+			t.Errorf("providerNamespace(%q) = %q; want %q", provider, got, want)
+		}
+	}
+}
+
+func TestGPGSignatureVerifierTrustKeysInDir(t *testing.T) {
+	key := generateTestKey(t, 0)
+
+	var armored bytes.Buffer
+	w, err := armor.Encode(&armored, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := key.Serialize(w); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "tf-trusted-keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "mykey.asc"), armored.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	v := &GPGSignatureVerifier{}
+	if err := v.TrustKeysInDir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	sums := []byte("deadbeef  terraform-provider-test_1.2.3_linux_amd64.zip\n")
+	sig := detachSign(t, key, sums)
+
+	if _, err := v.Verify(sums, sig, "any-namespace"); err != nil {
+		t.Fatalf("expected key loaded from dir to be trusted: %s", err)
+	}
+}
+
+// TestGPGSignatureVerifierTrustKeysInDirMissing verifies that a missing
+// key directory is treated as "no additional keys", not an error.
+func TestGPGSignatureVerifierTrustKeysInDirMissing(t *testing.T) {
+	v := &GPGSignatureVerifier{}
+	if err := v.TrustKeysInDir(filepath.Join(os.TempDir(), "tf-trusted-keys-does-not-exist")); err != nil {
+		t.Fatalf("expected no error for a missing key directory, got: %s", err)
+	}
+}
@@ -0,0 +1,303 @@
+package discovery
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+
+	"github.com/hashicorp/terraform/registry/response"
+)
+
+// archiveNameRe matches the release archive naming convention used by the
+// registry's release service and mirrored by FilesystemSource, e.g.
+// "terraform-provider-test_1.2.3_linux_amd64.zip".
+var archiveNameRe = regexp.MustCompile(`^terraform-provider-([^_]+)_([^_]+)_([^_]+)_([^._]+)\.zip$`)
+
+// pluginBinaryProtocolRe matches the plugin protocol suffix of an installed
+// provider executable's name, e.g. the "_x3" in
+// "terraform-provider-test_v1.2.3_x3". A release archive's single entry is
+// always named this way, so it's the only place a filesystem mirror has to
+// recover which plugin protocol version the release was built for.
+var pluginBinaryProtocolRe = regexp.MustCompile(`_x([0-9]+)$`)
+
+// InstallerSource is a source of provider releases that a ProviderInstaller
+// can consult in addition to (or instead of) the public Terraform registry.
+//
+// A source is expected to expose the same shape of data as the registry
+// API: a version listing for a named provider, and a per-version, per
+// platform download location complete with checksum and signature URLs.
+// This allows the existing resolution logic in listProviderVersions,
+// checkPluginProtocol and getProviderChecksum to operate against a source
+// without any special-casing.
+type InstallerSource interface {
+	// ListProviderVersions returns the versions of the named provider that
+	// are available from this source. It is not an error for the provider
+	// to be entirely unknown to this source; in that case an empty result
+	// and a nil error should be returned so that the installer can fall
+	// through to its other sources.
+	ListProviderVersions(provider string) (*response.TerraformProviderVersions, error)
+
+	// ProviderLocation returns the download location for the given
+	// provider and version, for the source's configured OS and Arch. It
+	// returns a nil location (and nil error) if this source does not have
+	// the requested provider/version/platform combination.
+	ProviderLocation(provider, version string) (*response.TerraformProviderPlatformLocation, error)
+}
+
+// FilesystemSource is an InstallerSource that reads providers from a local
+// directory tree laid out as:
+//
+//     <BaseDir>/<provider>/<version>/terraform-provider-<provider>_<version>_SHA256SUMS
+//     <BaseDir>/<provider>/<version>/terraform-provider-<provider>_<version>_SHA256SUMS.sig
+//     <BaseDir>/<provider>/<version>/terraform-provider-<provider>_<version>_<os>_<arch>.zip
+//
+// This mirrors the naming convention used by the registry's release
+// service, so the same SHA256SUMS parsing and signature verification code
+// paths apply unmodified. It is intended for air-gapped installs and for
+// caching provider releases alongside a CI build.
+type FilesystemSource struct {
+	// BaseDir is the root of the mirror directory tree.
+	BaseDir string
+
+	OS   string
+	Arch string
+}
+
+func (s *FilesystemSource) providerDir(provider string) string {
+	return filepath.Join(s.BaseDir, provider)
+}
+
+// ListProviderVersions implements InstallerSource.
+func (s *FilesystemSource) ListProviderVersions(provider string) (*response.TerraformProviderVersions, error) {
+	entries, err := ioutil.ReadDir(s.providerDir(provider))
+	if os.IsNotExist(err) {
+		return &response.TerraformProviderVersions{ID: provider}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading mirror directory for %s: %s", provider, err)
+	}
+
+	ret := &response.TerraformProviderVersions{ID: provider}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		versionMeta, err := s.versionMeta(provider, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		if versionMeta == nil {
+			// No recognizable release archives for this version directory.
+			continue
+		}
+		ret.Versions = append(ret.Versions, versionMeta)
+	}
+	return ret, nil
+}
+
+// versionMeta builds the response.TerraformProviderVersion describing every
+// release archive present for provider/version, recovering the platforms it
+// covers from the archive filenames and the plugin protocols it supports
+// from the name of the executable packaged inside each archive, since a
+// filesystem mirror has nowhere else to record either. It returns a nil
+// version (and nil error) if no archives are present for this version.
+func (s *FilesystemSource) versionMeta(provider, version string) (*response.TerraformProviderVersion, error) {
+	dir := filepath.Join(s.providerDir(provider), version)
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading mirror directory for %s %s: %s", provider, version, err)
+	}
+
+	seenProtocols := map[string]bool{}
+	ret := &response.TerraformProviderVersion{Version: version}
+	for _, entry := range entries {
+		meta, ok := parseMirrorArchiveName(entry.Name())
+		if !ok || meta.provider != provider || meta.version != version {
+			continue
+		}
+
+		protocol, err := archivePluginProtocol(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("error reading plugin protocol from %s: %s", entry.Name(), err)
+		}
+
+		ret.Platforms = append(ret.Platforms, &response.TerraformProviderPlatform{OS: meta.os, Arch: meta.arch})
+		if !seenProtocols[protocol] {
+			seenProtocols[protocol] = true
+			ret.Protocols = append(ret.Protocols, protocol)
+		}
+	}
+	if len(ret.Platforms) == 0 {
+		return nil, nil
+	}
+	return ret, nil
+}
+
+// archivePluginProtocol opens the release archive at path and recovers the
+// plugin protocol version it was built for from the name of its single
+// packaged executable, e.g. "terraform-provider-test_v1.2.3_x3".
+func archivePluginProtocol(path string) (string, error) {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	for _, f := range r.File {
+		if m := pluginBinaryProtocolRe.FindStringSubmatch(f.Name); m != nil {
+			return m[1], nil
+		}
+	}
+	return "", fmt.Errorf("no provider executable found in %s", filepath.Base(path))
+}
+
+// ProviderLocation implements InstallerSource.
+func (s *FilesystemSource) ProviderLocation(provider, version string) (*response.TerraformProviderPlatformLocation, error) {
+	dir := filepath.Join(s.providerDir(provider), version)
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading mirror directory for %s %s: %s", provider, version, err)
+	}
+
+	for _, entry := range entries {
+		meta, ok := parseMirrorArchiveName(entry.Name())
+		if !ok || meta.os != s.OS || meta.arch != s.Arch {
+			continue
+		}
+
+		filename := entry.Name()
+		base := fmt.Sprintf("terraform-provider-%s_%s", provider, version)
+		return &response.TerraformProviderPlatformLocation{
+			Filename:            filename,
+			DownloadURL:         (&url.URL{Scheme: "file", Path: filepath.ToSlash(filepath.Join(dir, filename))}).String(),
+			ShasumsURL:          (&url.URL{Scheme: "file", Path: filepath.ToSlash(filepath.Join(dir, base+"_SHA256SUMS"))}).String(),
+			ShasumsSignatureURL: (&url.URL{Scheme: "file", Path: filepath.ToSlash(filepath.Join(dir, base+"_SHA256SUMS.sig"))}).String(),
+		}, nil
+	}
+	return nil, nil
+}
+
+// HTTPMirrorSource is an InstallerSource that resolves providers against a
+// static HTTP/S index of JSON documents in the registry's own response
+// shapes, laid out as:
+//
+//     <BaseURL>/<provider>/index.json                  (version listing)
+//     <BaseURL>/<provider>/<version>/<os>_<arch>.json  (download location)
+//
+// The download location names the archive, SHA256SUMS and signature URLs,
+// so this allows a plain file server (or an S3 bucket exposed over HTTP) to
+// act as a provider mirror.
+type HTTPMirrorSource struct {
+	// BaseURL is the root of the mirror, e.g.
+	// "https://example.com/terraform-providers/".
+	BaseURL string
+
+	OS   string
+	Arch string
+
+	client *http.Client
+}
+
+func (s *HTTPMirrorSource) httpClient() *http.Client {
+	if s.client != nil {
+		return s.client
+	}
+	return httpClient
+}
+
+func (s *HTTPMirrorSource) providerURL(provider string, elem ...string) string {
+	u := s.BaseURL
+	if u[len(u)-1] != '/' {
+		u += "/"
+	}
+	return u + path.Join(append([]string{provider}, elem...)...)
+}
+
+// ListProviderVersions implements InstallerSource.
+func (s *HTTPMirrorSource) ListProviderVersions(provider string) (*response.TerraformProviderVersions, error) {
+	body, err := s.get(s.providerURL(provider, "index.json"))
+	if err != nil {
+		if err == errMirrorNotFound {
+			return &response.TerraformProviderVersions{ID: provider}, nil
+		}
+		return nil, err
+	}
+
+	var ret response.TerraformProviderVersions
+	if err := json.Unmarshal(body, &ret); err != nil {
+		return nil, fmt.Errorf("error decoding version index for %s: %s", provider, err)
+	}
+	ret.ID = provider
+	return &ret, nil
+}
+
+// ProviderLocation implements InstallerSource.
+func (s *HTTPMirrorSource) ProviderLocation(provider, version string) (*response.TerraformProviderPlatformLocation, error) {
+	body, err := s.get(s.providerURL(provider, version, fmt.Sprintf("%s_%s.json", s.OS, s.Arch)))
+	if err != nil {
+		if err == errMirrorNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var ret response.TerraformProviderPlatformLocation
+	if err := json.Unmarshal(body, &ret); err != nil {
+		return nil, fmt.Errorf("error decoding platform location for %s %s: %s", provider, version, err)
+	}
+	return &ret, nil
+}
+
+var errMirrorNotFound = fmt.Errorf("not found in mirror")
+
+func (s *HTTPMirrorSource) get(url string) ([]byte, error) {
+	resp, err := s.httpClient().Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errMirrorNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+type mirrorArchiveName struct {
+	provider string
+	version  string
+	os       string
+	arch     string
+}
+
+// parseMirrorArchiveName extracts the provider/version/os/arch components
+// from a release archive filename of the form produced by the registry's
+// release service, e.g. "terraform-provider-test_1.2.3_linux_amd64.zip".
+// The plugin protocol a release supports isn't part of this filename; see
+// archivePluginProtocol for how that's recovered instead.
+func parseMirrorArchiveName(name string) (mirrorArchiveName, bool) {
+	m := archiveNameRe.FindStringSubmatch(name)
+	if m == nil {
+		return mirrorArchiveName{}, false
+	}
+	return mirrorArchiveName{
+		provider: m[1],
+		version:  m[2],
+		os:       m[3],
+		arch:     m[4],
+	}, true
+}
@@ -0,0 +1,128 @@
+package discovery
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/hashicorp/terraform/registry"
+	"github.com/mitchellh/cli"
+)
+
+func TestFilesystemSourceListAndLocate(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "tf-plugin-mirror")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	dir := filepath.Join(tmpDir, "mirror-test", "1.2.3")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	archiveName := "terraform-provider-mirror-test_1.2.3_" + runtime.GOOS + "_" + runtime.GOARCH + ".zip"
+	writeTestArchive(t, filepath.Join(dir, archiveName), "terraform-provider-mirror-test_v1.2.3_x3")
+
+	src := &FilesystemSource{BaseDir: tmpDir, OS: runtime.GOOS, Arch: runtime.GOARCH}
+
+	versions, err := src.ListProviderVersions("mirror-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions.Versions) != 1 || versions.Versions[0].Version != "1.2.3" {
+		t.Fatalf("unexpected versions: %#v", versions.Versions)
+	}
+
+	loc, err := src.ProviderLocation("mirror-test", "1.2.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc == nil || loc.Filename != archiveName {
+		t.Fatalf("unexpected location: %#v", loc)
+	}
+
+	// A provider not present in the mirror should fall through cleanly.
+	versions, err = src.ListProviderVersions("not-mirrored")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions.Versions) != 0 {
+		t.Fatalf("expected no versions, got %#v", versions.Versions)
+	}
+}
+
+// TestProviderInstallerGetFromMirrorSuccess verifies that a provider can
+// actually be installed end-to-end from a FilesystemSource mirror, with no
+// registry involved at all.
+func TestProviderInstallerGetFromMirrorSuccess(t *testing.T) {
+	tmpMirror, err := ioutil.TempDir("", "tf-plugin-mirror")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpMirror)
+
+	tmpDir, err := ioutil.TempDir("", "tf-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	versionDir := filepath.Join(tmpMirror, "test", "1.2.3")
+	if err := os.MkdirAll(versionDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	archiveName := "terraform-provider-test_1.2.3_" + runtime.GOOS + "_" + runtime.GOARCH + ".zip"
+	writeTestArchive(t, filepath.Join(versionDir, archiveName), "terraform-provider-test_v1.2.3_x3")
+
+	i := &ProviderInstaller{
+		Dir: tmpDir,
+		PluginProtocolVersion: 3,
+		SkipVerify:            true,
+		Ui:                    cli.NewMockUi(),
+		registry:              registry.NewClient(nil, nil, nil),
+		Sources:               []InstallerSource{&FilesystemSource{BaseDir: tmpMirror, OS: runtime.GOOS, Arch: runtime.GOARCH}},
+	}
+
+	meta, err := i.Get("test", AllVersions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Name != "test" || meta.Version != VersionStr("1.2.3") {
+		t.Fatalf("unexpected meta: %#v", meta)
+	}
+}
+
+// TestProviderInstallerGetFromMirror verifies that when a provider is in
+// neither the configured mirror source nor a reachable registry, Get fails
+// with ErrorNoSuchProvider.
+func TestProviderInstallerGetFromMirror(t *testing.T) {
+	tmpMirror, err := ioutil.TempDir("", "tf-plugin-mirror")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpMirror)
+
+	tmpDir, err := ioutil.TempDir("", "tf-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	i := &ProviderInstaller{
+		Dir: tmpDir,
+		PluginProtocolVersion: 3,
+		SkipVerify:            true,
+		Ui:                    cli.NewMockUi(),
+		registry:              registry.NewClient(nil, nil, nil),
+		Sources:               []InstallerSource{&FilesystemSource{BaseDir: tmpMirror, OS: runtime.GOOS, Arch: runtime.GOARCH}},
+	}
+
+	// With nothing in the mirror and no reachable registry, resolution
+	// should fail with ErrorNoSuchProvider rather than panicking.
+	if _, err := i.Get("nonexist", AllVersions); err != ErrorNoSuchProvider {
+		t.Fatalf("want ErrorNoSuchProvider, got %v", err)
+	}
+}